@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ManifestFile is the name of the incremental-processing manifest written
+// into Dirs.Out.
+const ManifestFile = ".resizer-cache.json"
+
+// Manifest records, for every (source file, preset) pair already processed,
+// a hash of the source bytes and preset parameters so that unchanged work
+// can be skipped on subsequent runs.
+type Manifest struct {
+	mu      sync.Mutex
+	Entries map[string]string `json:"entries"`
+}
+
+// loadManifest reads ManifestFile from out, returning an empty manifest if
+// it can't be opened (because it doesn't exist yet or for any other reason,
+// since the manifest is a best-effort cache rather than a source of truth).
+// A manifest that opens but can't be read or parsed is reported as an error.
+func loadManifest(out Store) (*Manifest, error) {
+	r, err := out.Open(ManifestFile)
+	if err != nil {
+		return &Manifest{Entries: map[string]string{}}, nil
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v: %v", ManifestFile, err)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", ManifestFile, err)
+	}
+	if m.Entries == nil {
+		m.Entries = map[string]string{}
+	}
+	return m, nil
+}
+
+// save writes the manifest into out.
+func (m *Manifest) save(out Store) error {
+	m.mu.Lock()
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w, err := out.Create(ManifestFile)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	_, err = w.Write(data)
+	return err
+}
+
+// upToDate reports whether file+preset can be skipped: the manifest must
+// record a matching hash and the expected output file must still exist in
+// out. It always returns the current hash of the source file so the caller
+// can record it after processing without hashing twice.
+func (m *Manifest) upToDate(in Store, out Store, preset Preset, file string, force bool) (skip bool, srcHash string, err error) {
+	srcHash, err = fileHash(in, file)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to hash %v: %v", file, err)
+	}
+	if force {
+		return false, srcHash, nil
+	}
+
+	m.mu.Lock()
+	want, ok := m.Entries[manifestKey(preset, file)]
+	m.mu.Unlock()
+	if !ok || want != jobHash(srcHash, presetHash(preset)) {
+		return false, srcHash, nil
+	}
+
+	r, err := out.Open(encodedName(preset, file))
+	if err != nil {
+		return false, srcHash, nil
+	}
+	r.Close()
+	return true, srcHash, nil
+}
+
+// set records that file+preset at srcHash has been processed.
+func (m *Manifest) set(preset Preset, file string, srcHash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Entries[manifestKey(preset, file)] = jobHash(srcHash, presetHash(preset))
+}
+
+func manifestKey(preset Preset, file string) string {
+	return file + "::" + preset.Name
+}
+
+func fileHash(in Store, file string) (string, error) {
+	r, err := in.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func presetHash(preset Preset) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%d|%d|%s|%s|%s|%d|%d|%t|%d|%d",
+		preset.Name, preset.Width, preset.Height, preset.Quality, preset.Mode, preset.Anchor,
+		preset.Format, preset.Encode.JPEGQuality, preset.Encode.PNGCompression,
+		preset.Encode.WebPLossless, preset.Encode.AVIFSpeed, preset.Encode.AVIFQuality)))
+	return hex.EncodeToString(sum[:])
+}
+
+func jobHash(srcHash string, presetHash string) string {
+	sum := sha256.Sum256([]byte(srcHash + presetHash))
+	return hex.EncodeToString(sum[:])
+}
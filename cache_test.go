@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestPresetHashChangesWithEncodeOptions(t *testing.T) {
+	base := Preset{Name: "thumb", Width: 100, Height: 100, Quality: 80, Mode: "fit", Format: "jpeg"}
+
+	variants := []Preset{
+		base,
+		{Name: "thumb", Width: 100, Height: 100, Quality: 80, Mode: "fit", Format: "webp"},
+		{Name: "thumb", Width: 100, Height: 100, Quality: 80, Mode: "fit", Format: "jpeg",
+			Encode: EncodeOptions{JPEGQuality: 90}},
+		{Name: "thumb", Width: 100, Height: 100, Quality: 80, Mode: "fit", Format: "jpeg",
+			Encode: EncodeOptions{PNGCompression: 9}},
+		{Name: "thumb", Width: 100, Height: 100, Quality: 80, Mode: "fit", Format: "webp",
+			Encode: EncodeOptions{WebPLossless: true}},
+		{Name: "thumb", Width: 100, Height: 100, Quality: 80, Mode: "fit", Format: "avif",
+			Encode: EncodeOptions{AVIFSpeed: 4, AVIFQuality: 50}},
+	}
+
+	seen := map[string]Preset{}
+	baseHash := presetHash(base)
+	for _, p := range variants {
+		h := presetHash(p)
+		if other, ok := seen[h]; ok {
+			t.Fatalf("presetHash collided for %+v and %+v", other, p)
+		}
+		seen[h] = p
+	}
+	if h := presetHash(base); h != baseHash {
+		t.Fatalf("presetHash(base) is not stable across calls: %v != %v", h, baseHash)
+	}
+}
+
+func TestPresetHashStableForIdenticalPreset(t *testing.T) {
+	a := Preset{Name: "thumb", Width: 100, Height: 100, Quality: 80, Mode: "fit", Anchor: "center",
+		Format: "jpeg", Encode: EncodeOptions{JPEGQuality: 85}}
+	b := a
+
+	if presetHash(a) != presetHash(b) {
+		t.Fatalf("presetHash differs for identical presets: %v vs %v", presetHash(a), presetHash(b))
+	}
+}
+
+func TestJobHashDiffersPerInput(t *testing.T) {
+	h1 := jobHash("src1", "preset1")
+	h2 := jobHash("src2", "preset1")
+	h3 := jobHash("src1", "preset2")
+
+	if h1 == h2 || h1 == h3 || h2 == h3 {
+		t.Fatalf("jobHash did not distinguish inputs: %v %v %v", h1, h2, h3)
+	}
+}
+
+func TestManifestSetAndUpToDate(t *testing.T) {
+	in := newLocalStore(t.TempDir())
+	out := newLocalStore(t.TempDir())
+
+	if w, err := in.Create("photo.jpg"); err != nil {
+		t.Fatal(err)
+	} else {
+		w.Write([]byte("source bytes"))
+		w.Close()
+	}
+
+	preset := Preset{Name: "thumb", Width: 100, Height: 100, Quality: 80, Mode: "fit"}
+	m := &Manifest{Entries: map[string]string{}}
+
+	skip, srcHash, err := m.upToDate(in, out, preset, "photo.jpg", false)
+	if err != nil {
+		t.Fatalf("upToDate returned error: %v", err)
+	}
+	if skip {
+		t.Fatal("upToDate reported skip before any processing happened")
+	}
+
+	m.set(preset, "photo.jpg", srcHash)
+
+	// Without the output file actually present, upToDate must not report
+	// up to date even though the manifest entry matches.
+	skip, _, err = m.upToDate(in, out, preset, "photo.jpg", false)
+	if err != nil {
+		t.Fatalf("upToDate returned error: %v", err)
+	}
+	if skip {
+		t.Fatal("upToDate reported skip with no output file written")
+	}
+
+	if w, err := out.Create(encodedName(preset, "photo.jpg")); err != nil {
+		t.Fatal(err)
+	} else {
+		w.Close()
+	}
+
+	skip, _, err = m.upToDate(in, out, preset, "photo.jpg", false)
+	if err != nil {
+		t.Fatalf("upToDate returned error: %v", err)
+	}
+	if !skip {
+		t.Fatal("upToDate did not report skip once manifest and output agree")
+	}
+
+	if skip, _, err = m.upToDate(in, out, preset, "photo.jpg", true); err != nil {
+		t.Fatalf("upToDate returned error: %v", err)
+	} else if skip {
+		t.Fatal("upToDate reported skip with force=true")
+	}
+}
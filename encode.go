@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// EncodeOptions configures the format-specific encoders used when saving a
+// processed image. Zero values fall back to sensible defaults for each
+// format.
+type EncodeOptions struct {
+	JPEGQuality    int  `yaml:"jpeg_quality"`
+	PNGCompression int  `yaml:"png_compression"`
+	WebPLossless   bool `yaml:"webp_lossless"`
+	AVIFSpeed      int  `yaml:"avif_speed"`
+	AVIFQuality    int  `yaml:"avif_quality"`
+}
+
+// formatExtension returns the file extension for preset format, or "" if
+// the preset wants to keep the source file's extension ("original" or
+// unset).
+func formatExtension(format string) string {
+	switch format {
+	case "jpeg":
+		return ".jpg"
+	case "png":
+		return ".png"
+	case "webp":
+		return ".webp"
+	case "avif":
+		return ".avif"
+	default:
+		return ""
+	}
+}
+
+// encodedName returns the name processAndSave's result is saved/served
+// under for the given preset and source file, honoring preset.Format.
+func encodedName(preset Preset, file string) string {
+	ext := formatExtension(preset.Format)
+	if ext == "" {
+		return outputName(preset, file)
+	}
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+	return outputName(preset, base) + ext
+}
+
+// saveImage encodes dst according to preset.Format/preset.Encode and writes
+// it into out, returning the name it was written under. For backends such
+// as S3 or GCS the upload only actually happens when the writer is closed,
+// so a failing Close must surface as an error rather than be discarded.
+func saveImage(dst *image.NRGBA, preset Preset, out Store, file string) (name string, err error) {
+	name = encodedName(preset, file)
+	w, err := out.Create(name)
+	if err != nil {
+		return name, err
+	}
+	defer func() {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	switch strings.ToLower(formatExtensionFor(preset, file)) {
+	case ".webp":
+		return name, saveWebP(w, dst, preset.Encode)
+	case ".avif":
+		return name, saveAVIF(w, dst, preset.Encode)
+	}
+
+	format, ferr := imaging.FormatFromExtension(formatExtensionFor(preset, file))
+	if ferr != nil {
+		return name, fmt.Errorf("unsupported preset format %q", preset.Format)
+	}
+
+	var opts []imaging.EncodeOption
+	switch format {
+	case imaging.JPEG:
+		quality := preset.Quality
+		if preset.Encode.JPEGQuality != 0 {
+			quality = preset.Encode.JPEGQuality
+		}
+		opts = append(opts, imaging.JPEGQuality(quality))
+	case imaging.PNG:
+		level := png.DefaultCompression
+		if preset.Encode.PNGCompression != 0 {
+			level = png.CompressionLevel(preset.Encode.PNGCompression)
+		}
+		opts = append(opts, imaging.PNGCompressionLevel(level))
+	}
+
+	return name, imaging.Encode(w, dst, format, opts...)
+}
+
+// formatExtensionFor is like formatExtension, but falls back to file's own
+// extension when preset.Format is unset/"original".
+func formatExtensionFor(preset Preset, file string) string {
+	if ext := formatExtension(preset.Format); ext != "" {
+		return ext
+	}
+	return filepath.Ext(file)
+}
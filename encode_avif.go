@@ -0,0 +1,24 @@
+//go:build avif
+
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/Kagami/go-avif"
+)
+
+// saveAVIF encodes dst as AVIF into w. Built only with `-tags avif`, since
+// go-avif wraps a cgo aom binding.
+func saveAVIF(w io.Writer, dst *image.NRGBA, opts EncodeOptions) error {
+	speed := opts.AVIFSpeed
+	if speed == 0 {
+		speed = avif.DefaultOptions.Speed
+	}
+	quality := opts.AVIFQuality
+	if quality == 0 {
+		quality = avif.DefaultOptions.Quality
+	}
+	return avif.Encode(w, dst, &avif.Options{Speed: speed, Quality: quality})
+}
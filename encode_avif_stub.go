@@ -0,0 +1,16 @@
+//go:build !avif
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// saveAVIF is a stub used when the binary is built without `-tags avif`, so
+// that projects which don't need the cgo aom dependency aren't forced to
+// pull it in.
+func saveAVIF(w io.Writer, dst *image.NRGBA, opts EncodeOptions) error {
+	return fmt.Errorf("avif output requires building with -tags avif")
+}
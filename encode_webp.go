@@ -0,0 +1,16 @@
+//go:build webp
+
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+// saveWebP encodes dst as WebP into w. Built only with `-tags webp`, since
+// chai2010/webp wraps a cgo libwebp binding.
+func saveWebP(w io.Writer, dst *image.NRGBA, opts EncodeOptions) error {
+	return webp.Encode(w, dst, &webp.Options{Lossless: opts.WebPLossless, Quality: 80})
+}
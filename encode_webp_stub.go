@@ -0,0 +1,16 @@
+//go:build !webp
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+)
+
+// saveWebP is a stub used when the binary is built without `-tags webp`, so
+// that projects which don't need the cgo libwebp dependency aren't forced
+// to pull it in.
+func saveWebP(w io.Writer, dst *image.NRGBA, opts EncodeOptions) error {
+	return fmt.Errorf("webp output requires building with -tags webp")
+}
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"strings"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
-	"path/filepath"
+	"runtime"
 	"github.com/disintegration/imaging"
 	"gopkg.in/yaml.v2"
 	"time"
 	"sync"
+
+	// Registers a decoder for image.Decode so .webp sources can always be
+	// read, regardless of whether the binary was built with -tags webp:
+	// that tag only gates the cgo libwebp encoder used to *produce* WebP
+	// output, not the ability to accept it as input.
+	_ "golang.org/x/image/webp"
 )
 
 const (
@@ -30,16 +39,25 @@ var anchorMap = map[string]imaging.Anchor{
 	"bottomRight": imaging.BottomRight,
 }
 
-var wg sync.WaitGroup
-
 type Conf struct {
 	Dirs Dir
 	Presets []Preset `yaml:"presets"`
+	Workers int `yaml:"workers"`
 }
 
 type Dir struct {
-	In string `yaml:"in"`
-	Out string `yaml:"out"`
+	In DirConf `yaml:"in"`
+	Out DirConf `yaml:"out"`
+}
+
+// DirConf describes where a Store reads or writes its files. Backend
+// selects the implementation ("local", the default, "s3" or "gcs"); the
+// remaining fields are interpreted by that backend.
+type DirConf struct {
+	Backend string `yaml:"backend"`
+	Path string `yaml:"path"`
+	Bucket string `yaml:"bucket"`
+	Region string `yaml:"region"`
 }
 
 type Preset struct {
@@ -49,6 +67,10 @@ type Preset struct {
 	Quality int `yaml:"quality"`
 	Mode string `yaml:"mode"`
 	Anchor string `yaml:"anchor"`
+	// Format selects the output encoder: "jpeg", "png", "webp", "avif" or
+	// "original" (default) to keep the source file's format.
+	Format string `yaml:"format"`
+	Encode EncodeOptions `yaml:"encode"`
 }
 
 func getConf() (*Conf, error) {
@@ -67,38 +89,26 @@ func getConf() (*Conf, error) {
 }
 
 func makeDirectoryIfNotExists(path string) error {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
 		return os.Mkdir(path, os.ModeDir|0755)
 	}
 	return nil
 }
 
 func checkDirectoryIfExists(path string) bool {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
 		return false
 	}
 	return true
 }
 
 func isImage(ext string) bool {
-	return (strings.ToLower(ext) == ".jpg" ||
-	strings.ToLower(ext) == ".jpeg" ||
-	strings.ToLower(ext) == ".png")
-}
-
-func readFileFromDir(indir string) []string {
-	var files []string
-
-    err := filepath.Walk(indir, func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() && isImage(filepath.Ext(path)) {
-        	files = append(files, info.Name())
-		}
-        return nil
-    })
-    if err != nil {
-        panic(err)
-    }
-	return files
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg", ".png", ".webp", ".tif", ".tiff", ".gif", ".bmp":
+		return true
+	default:
+		return false
+	}
 }
 
 func timeTrack(start time.Time, name string) {
@@ -106,61 +116,196 @@ func timeTrack(start time.Time, name string) {
     log.Printf("%s took %s", name, elapsed)
 }
 
-func processImage(Preset Preset, indir string, outdir string, file string) {
-	defer wg.Done()
+// processImage applies preset to the image named file in in and returns the
+// resized image. It performs no I/O other than reading the source file, so
+// callers (batch mode, the HTTP server) decide how and where to save it.
+func processImage(preset Preset, in Store, file string) (*image.NRGBA, error) {
+	r, err := in.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image %v: %v", file, err)
+	}
+	defer r.Close()
 
-	src, err := imaging.Open(indir+"/"+file)
+	src, err := imaging.Decode(r)
 	if err != nil {
-		log.Fatalf("Failed to open image: %v", err)
+		return nil, fmt.Errorf("failed to decode image %v: %v", file, err)
 	}
 
 	var dst *image.NRGBA
-	switch Preset.Mode {
+	switch preset.Mode {
 	case "crop":
-		dst = imaging.CropAnchor(src, Preset.Width, Preset.Height, anchorMap[Preset.Anchor])
+		dst = imaging.CropAnchor(src, preset.Width, preset.Height, anchorMap[preset.Anchor])
 	case "fill":
-		dst = imaging.Fill(src, Preset.Width, Preset.Height, anchorMap[Preset.Anchor], imaging.Lanczos)
+		dst = imaging.Fill(src, preset.Width, preset.Height, anchorMap[preset.Anchor], imaging.Lanczos)
 	case "fit":
-		dst = imaging.Fit(src, Preset.Width, Preset.Height, imaging.Lanczos)
+		dst = imaging.Fit(src, preset.Width, preset.Height, imaging.Lanczos)
+	default:
+		return nil, fmt.Errorf("unknown preset mode %q", preset.Mode)
+	}
+
+	return dst, nil
+}
+
+// outputName returns the default preset-prefixed name for file, keeping its
+// original extension. encodedName builds on this when preset.Format
+// requests a different one.
+func outputName(preset Preset, file string) string {
+	return preset.Name + "_" + file
+}
+
+// processAndSave runs processImage and writes the result into out,
+// returning the name it was written under.
+func processAndSave(preset Preset, in Store, out Store, file string) (string, error) {
+	dst, err := processImage(preset, in, file)
+	if err != nil {
+		return "", err
 	}
-	
-	err = imaging.Save(dst, outdir+"/"+Preset.Name+"_"+file, imaging.JPEGQuality(Preset.Quality))
+
+	name, err := saveImage(dst, preset, out, file)
 	if err != nil {
-		log.Fatalf("Failed to save image: %v", err)
+		return "", fmt.Errorf("failed to save image %v: %v", name, err)
 	}
+	return name, nil
 }
 
 func main() {
+	serve := flag.Bool("serve", false, "run as an HTTP server instead of processing once and exiting")
+	addr := flag.String("addr", ":8080", "address to listen on when -serve is set")
+	force := flag.Bool("force", false, "reprocess every file even if the cache manifest says it's up to date")
+	watch := flag.Bool("watch", false, "watch Dirs.In and process new/modified files as they appear")
+	flag.Parse()
+
 	log.Println("Reading configuration file...")
-	
+
 	conf, err := getConf()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Println("Check if input dir exists...")
-	if !checkDirectoryIfExists(conf.Dirs.In) {
-		makeDirectoryIfNotExists(conf.Dirs.In)
-		log.Fatalln("Input directory does not exist, making one for you")
+	if conf.Dirs.In.Backend == "" || conf.Dirs.In.Backend == "local" {
+		log.Println("Check if input dir exists...")
+		if !checkDirectoryIfExists(conf.Dirs.In.Path) {
+			makeDirectoryIfNotExists(conf.Dirs.In.Path)
+			log.Fatalln("Input directory does not exist, making one for you")
+		}
+	}
+
+	if conf.Dirs.Out.Backend == "" || conf.Dirs.Out.Backend == "local" {
+		log.Println("Making output dir...")
+		makeDirectoryIfNotExists(conf.Dirs.Out.Path)
 	}
 
-	log.Println("Making output dir...")
-	makeDirectoryIfNotExists(conf.Dirs.Out)
+	if *serve {
+		server := NewServer(conf)
+		log.Printf("Listening on %v...", *addr)
+		log.Fatal(server.ListenAndServe(*addr))
+		return
+	}
+
+	if *watch {
+		log.Fatal(Watch(conf))
+		return
+	}
 
-	log.Printf("Reading file inside %v dir...", conf.Dirs.In)
-	files := readFileFromDir(conf.Dirs.In)
+	if err := Run(conf, *force); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// job is one (preset, file) pair to be processed by the worker pool.
+type job struct {
+	preset Preset
+	file   string
+}
+
+// Run processes every file in conf.Dirs.In against every preset in conf
+// using a pool of conf.Workers goroutines (defaulting to runtime.NumCPU()),
+// so memory usage stays bounded regardless of how many files and presets are
+// combined. Jobs already recorded as up to date in the cache manifest are
+// skipped unless force is true. It returns an aggregated error for every job
+// that failed instead of aborting the whole batch.
+func Run(conf *Conf, force bool) error {
+	in, err := NewStore(conf.Dirs.In)
+	if err != nil {
+		return fmt.Errorf("failed to open input store: %v", err)
+	}
+	out, err := NewStore(conf.Dirs.Out)
+	if err != nil {
+		return fmt.Errorf("failed to open output store: %v", err)
+	}
+
+	log.Printf("Reading files from %v store...", conf.Dirs.In.Backend)
+	files, err := in.List()
+	if err != nil {
+		return fmt.Errorf("failed to list input files: %v", err)
+	}
 	log.Printf("Found %v files", len(files))
 
+	manifest, err := loadManifest(out)
+	if err != nil {
+		return fmt.Errorf("failed to load cache manifest: %v", err)
+	}
+
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	log.Printf("Processing with %v workers...", workers)
+
+	jobs := make(chan job, workers)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var jobErrs []error
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				skip, srcHash, err := manifest.upToDate(in, out, j.preset, j.file, force)
+				if err != nil {
+					mu.Lock()
+					jobErrs = append(jobErrs, fmt.Errorf("%v (preset %v): %v", j.file, j.preset.Name, err))
+					mu.Unlock()
+					continue
+				}
+				if skip {
+					log.Printf("Skipping %v (preset %v): up to date", j.file, j.preset.Name)
+					continue
+				}
+
+				if _, err := processAndSave(j.preset, in, out, j.file); err != nil {
+					mu.Lock()
+					jobErrs = append(jobErrs, fmt.Errorf("%v (preset %v): %v", j.file, j.preset.Name, err))
+					mu.Unlock()
+					continue
+				}
+
+				mu.Lock()
+				manifest.set(j.preset, j.file, srcHash)
+				mu.Unlock()
+			}
+		}()
+	}
+
 	defer timeTrack(time.Now(), "processing")
 	for i, preset := range conf.Presets {
 		log.Printf("Generating Preset %v, Preset name: %v...", i, preset.Name)
 
 		for _, file := range files {
-			log.Println("Working with file", conf.Dirs.In+"/"+file)
-			wg.Add(1)
-			go processImage(preset, conf.Dirs.In, conf.Dirs.Out, file)
+			log.Println("Working with file", file)
+			jobs <- job{preset, file}
 		}
 	}
-
+	close(jobs)
 	wg.Wait()
+
+	if err := manifest.save(out); err != nil {
+		log.Printf("Failed to save cache manifest: %v", err)
+	}
+
+	if len(jobErrs) > 0 {
+		return fmt.Errorf("%v job(s) failed: %w", len(jobErrs), errors.Join(jobErrs...))
+	}
+	return nil
 }
\ No newline at end of file
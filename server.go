@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Server exposes the preset pipeline configured in conf.yaml over HTTP.
+type Server struct {
+	conf *Conf
+	in   Store
+	out  Store
+	mux  *http.ServeMux
+}
+
+// NewServer builds a Server that resizes on demand using conf's presets and
+// caches results in conf.Dirs.Out.
+func NewServer(conf *Conf) *Server {
+	in, err := NewStore(conf.Dirs.In)
+	if err != nil {
+		log.Fatalf("Failed to open input store: %v", err)
+	}
+	out, err := NewStore(conf.Dirs.Out)
+	if err != nil {
+		log.Fatalf("Failed to open output store: %v", err)
+	}
+
+	s := &Server{conf: conf, in: in, out: out, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/images/", s.handleImage)
+	s.mux.HandleFunc("/upload", s.handleUpload)
+	return s
+}
+
+// ListenAndServe starts the HTTP server on addr.
+func (s *Server) ListenAndServe(addr string) error {
+	return http.ListenAndServe(addr, s.mux)
+}
+
+func (s *Server) presetByName(name string) (Preset, bool) {
+	for _, preset := range s.conf.Presets {
+		if preset.Name == name {
+			return preset, true
+		}
+	}
+	return Preset{}, false
+}
+
+// sanitizeFileName strips any directory component from name and rejects
+// the result if it still isn't a plain file name, so a value derived from a
+// URL path, a multipart filename or a user-supplied header can never be
+// used to make a Store read or write outside the directory it's rooted at.
+func sanitizeFileName(name string) (string, error) {
+	clean := filepath.Base(filepath.Clean(name))
+	if clean == "" || clean == "." || clean == ".." || clean == string(filepath.Separator) {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return clean, nil
+}
+
+// handleImage serves GET /images/{preset}/{file}, resizing on demand and
+// caching the result in Dirs.Out for subsequent requests.
+func (s *Server) handleImage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/images/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "expected /images/{preset}/{file}", http.StatusBadRequest)
+		return
+	}
+	presetName, file := parts[0], parts[1]
+
+	preset, ok := s.presetByName(presetName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown preset %q", presetName), http.StatusNotFound)
+		return
+	}
+
+	file, err := sanitizeFileName(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := encodedName(preset, file)
+	cached, err := s.out.Open(name)
+	if err != nil {
+		name, err = processAndSave(preset, s.in, s.out, file)
+		if err != nil {
+			log.Printf("Error processing %v: %v", file, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cached, err = s.out.Open(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	defer cached.Close()
+
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	if _, err := io.Copy(w, cached); err != nil {
+		log.Printf("Error streaming %v: %v", name, err)
+	}
+}
+
+// handleUpload serves POST /upload, similar to `docker import`: the source
+// image is fetched from a "url" query parameter if one is given, else taken
+// from a multipart form file field named "file", else read from the raw
+// body and named by the "filename" query parameter. The image is written
+// into Dirs.In under its original or derived name.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if src := r.URL.Query().Get("url"); src != "" {
+		if err := s.importFromURL(src); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		// /upload is registered as an exact match, so r.URL.Path is always
+		// "/upload" here: the raw-body mode must take its filename from
+		// somewhere else, since docker import-style raw uploads don't carry
+		// one on the path.
+		if err := s.importFromReader(r.Body, r.URL.Query().Get("filename")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	defer file.Close()
+
+	if err := s.importFromReader(file, header.Filename); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) importFromURL(src string) error {
+	u, err := url.Parse(src)
+	if err != nil {
+		return fmt.Errorf("invalid url %q: %v", src, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	resp, err := importHTTPClient.Get(src)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %q: %v", src, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %q: status %v", src, resp.Status)
+	}
+
+	return s.importFromReader(resp.Body, filepath.Base(u.Path))
+}
+
+func (s *Server) importFromReader(r io.Reader, name string) error {
+	name, err := sanitizeFileName(name)
+	if err != nil {
+		return fmt.Errorf("could not determine a filename for upload: %v", err)
+	}
+	if !isImage(filepath.Ext(name)) {
+		return fmt.Errorf("unsupported file extension %q", filepath.Ext(name))
+	}
+
+	dst, err := s.in.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to create %v: %v", name, err)
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, r); err != nil {
+		return fmt.Errorf("failed to write %v: %v", name, err)
+	}
+	return nil
+}
+
+// importHTTPClient is used for /upload?url=... fetches. Its dialer resolves
+// the target itself and refuses to connect to anything other than a public
+// address, so the endpoint can't be used to reach internal services or
+// cloud metadata endpoints (SSRF). The resolved IP, not the hostname, is
+// what gets dialed, so a DNS response can't change between the check and
+// the connection.
+var importHTTPClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: dialPublicOnly,
+	},
+}
+
+func dialPublicOnly(ctx context.Context, network string, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		if !isPublicIP(ip.IP) {
+			lastErr = fmt.Errorf("refusing to connect to non-public address %v", ip.IP)
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses found for %v", host)
+	}
+	return nil, lastErr
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsPrivate() &&
+		!ip.IsUnspecified()
+}
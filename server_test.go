@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	cases := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "photo.jpg", want: "photo.jpg"},
+		{name: "../../etc/passwd", want: "passwd"},
+		{name: "a/b/c.png", want: "c.png"},
+		{name: "/etc/passwd", want: "passwd"},
+		{name: "..", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "", wantErr: true},
+		{name: "/", wantErr: true},
+	}
+
+	for _, c := range cases {
+		got, err := sanitizeFileName(c.name)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("sanitizeFileName(%q) = %q, want error", c.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("sanitizeFileName(%q) returned unexpected error: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	cases := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"1.1.1.1", true},
+		{"127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fe80::1", false},
+	}
+
+	for _, c := range cases {
+		ip := net.ParseIP(c.ip)
+		if ip == nil {
+			t.Fatalf("net.ParseIP(%q) returned nil", c.ip)
+		}
+		if got := isPublicIP(ip); got != c.want {
+			t.Errorf("isPublicIP(%q) = %v, want %v", c.ip, got, c.want)
+		}
+	}
+}
+
+func TestDialPublicOnlyRefusesPrivateTargets(t *testing.T) {
+	// loopback is never dialed: dialPublicOnly must resolve the host,
+	// reject every non-public address, and fail closed rather than
+	// falling through to a real connection attempt.
+	if _, err := dialPublicOnly(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", "80")); err == nil {
+		t.Error("dialPublicOnly(127.0.0.1:80) = nil error, want refusal")
+	}
+}
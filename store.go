@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// Store abstracts the place images are read from and written to, so the
+// same pipeline can run against a local directory or an object storage
+// bucket without the rest of the code caring which.
+type Store interface {
+	// Open opens name for reading.
+	Open(name string) (io.ReadCloser, error)
+	// Create opens name for writing, creating or truncating it.
+	Create(name string) (io.WriteCloser, error)
+	// List returns the names of every image file the store currently holds.
+	List() ([]string, error)
+}
+
+// NewStore builds the Store described by cfg.
+func NewStore(cfg DirConf) (Store, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return newLocalStore(cfg.Path), nil
+	case "s3":
+		return newS3Store(cfg)
+	case "gcs":
+		return newGCSStore(cfg)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+	}
+}
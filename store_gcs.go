@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// gcsStore is the Store backed by a Google Cloud Storage bucket, with
+// cfg.Path used as an object name prefix.
+type gcsStore struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+func newGCSStore(cfg DirConf) (*gcsStore, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("gcs store requires a bucket")
+	}
+
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCS client: %v", err)
+	}
+
+	return &gcsStore{bucket: client.Bucket(cfg.Bucket), prefix: cfg.Path}, nil
+}
+
+func (s *gcsStore) object(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *gcsStore) Open(name string) (io.ReadCloser, error) {
+	return s.bucket.Object(s.object(name)).NewReader(context.Background())
+}
+
+func (s *gcsStore) Create(name string) (io.WriteCloser, error) {
+	return s.bucket.Object(s.object(name)).NewWriter(context.Background()), nil
+}
+
+func (s *gcsStore) List() ([]string, error) {
+	var files []string
+	it := s.bucket.Objects(context.Background(), &storage.Query{Prefix: s.prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(attrs.Name, s.prefix)
+		name = strings.TrimPrefix(name, "/")
+		if isImage(filepath.Ext(name)) {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// localStore is the Store backed by a directory on the local filesystem,
+// i.e. the tool's original behavior.
+type localStore struct {
+	dir string
+}
+
+func newLocalStore(dir string) *localStore {
+	return &localStore{dir: dir}
+}
+
+func (s *localStore) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, name))
+}
+
+func (s *localStore) Create(name string) (io.WriteCloser, error) {
+	return os.Create(filepath.Join(s.dir, name))
+}
+
+func (s *localStore) List() ([]string, error) {
+	var files []string
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isImage(filepath.Ext(path)) {
+			files = append(files, info.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3Store is the Store backed by an S3 bucket, with cfg.Path used as a key
+// prefix.
+type s3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func newS3Store(cfg DirConf) (*s3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3 store requires a bucket")
+	}
+
+	opts := []func(*config.LoadOptions) error{}
+	if cfg.Region != "" {
+		opts = append(opts, config.WithRegion(cfg.Region))
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+
+	return &s3Store{client: s3.NewFromConfig(awsCfg), bucket: cfg.Bucket, prefix: cfg.Path}, nil
+}
+
+func (s *s3Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3Store) Open(name string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *s3Store) Create(name string) (io.WriteCloser, error) {
+	return newS3Writer(s, name), nil
+}
+
+func (s *s3Store) List() ([]string, error) {
+	var files []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			name := (*obj.Key)[len(s.prefix):]
+			for len(name) > 0 && name[0] == '/' {
+				name = name[1:]
+			}
+			if isImage(filepath.Ext(name)) {
+				files = append(files, name)
+			}
+		}
+	}
+	return files, nil
+}
+
+// s3Writer buffers a full object in memory and uploads it on Close, since
+// s3.PutObject needs to know the content length/be seekable.
+type s3Writer struct {
+	store *s3Store
+	name  string
+	buf   []byte
+}
+
+func newS3Writer(store *s3Store, name string) *s3Writer {
+	return &s3Writer{store: store, name: name}
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *s3Writer) Close() error {
+	_, err := w.store.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(w.store.bucket),
+		Key:    aws.String(w.store.key(w.name)),
+		Body:   bytes.NewReader(w.buf),
+	})
+	return err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// debounceWindow is how long we wait after the last event for a path before
+// enqueuing it, so write-to-tmp-then-rename patterns only trigger one job.
+const debounceWindow = 250 * time.Millisecond
+
+// Watch watches conf.Dirs.In and processes new or modified image files
+// against every preset in conf as they appear, using the same bounded
+// worker pool as Run. It blocks until the watcher is closed or an
+// unrecoverable error occurs. Dirs.In must use the local backend, since
+// fsnotify can only watch a local directory.
+func Watch(conf *Conf) error {
+	if conf.Dirs.In.Backend != "" && conf.Dirs.In.Backend != "local" {
+		return fmt.Errorf("watch mode requires a local input store, got backend %q", conf.Dirs.In.Backend)
+	}
+
+	in, err := NewStore(conf.Dirs.In)
+	if err != nil {
+		return fmt.Errorf("failed to open input store: %v", err)
+	}
+	out, err := NewStore(conf.Dirs.Out)
+	if err != nil {
+		return fmt.Errorf("failed to open output store: %v", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(conf.Dirs.In.Path); err != nil {
+		return err
+	}
+
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	log.Printf("Watching %v with %v workers...", conf.Dirs.In.Path, workers)
+
+	jobs := make(chan job, workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for j := range jobs {
+				if _, err := processAndSave(j.preset, in, out, j.file); err != nil {
+					log.Printf("Error processing %v: %v", j.file, err)
+				}
+			}
+		}()
+	}
+
+	var mu sync.Mutex
+	timers := make(map[string]*time.Timer)
+	closing := false
+	// pending tracks debounce callbacks that have been scheduled but haven't
+	// finished enqueuing yet, so shutdown can wait for them before jobs is
+	// closed instead of risking a send on a closed channel.
+	var pending sync.WaitGroup
+
+	enqueue := func(file string) {
+		for _, preset := range conf.Presets {
+			jobs <- job{preset, file}
+		}
+	}
+
+	shutdown := func() {
+		mu.Lock()
+		closing = true
+		mu.Unlock()
+		pending.Wait()
+		close(jobs)
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				shutdown()
+				return nil
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			if !isImage(filepath.Ext(event.Name)) {
+				continue
+			}
+
+			file := filepath.Base(event.Name)
+			mu.Lock()
+			if closing {
+				mu.Unlock()
+				continue
+			}
+			if t, ok := timers[file]; ok && t.Stop() {
+				pending.Done()
+			}
+			pending.Add(1)
+			timers[file] = time.AfterFunc(debounceWindow, func() {
+				mu.Lock()
+				delete(timers, file)
+				skip := closing
+				mu.Unlock()
+				defer pending.Done()
+				if skip {
+					return
+				}
+				log.Printf("Processing %v...", file)
+				enqueue(file)
+			})
+			mu.Unlock()
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				shutdown()
+				return nil
+			}
+			log.Printf("Watch error: %v", err)
+		}
+	}
+}